@@ -0,0 +1,115 @@
+// Copyright (c) 2014 David R. Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package arith is an example consumer of lexer/unilex: a tokenizer for a
+small arithmetic-expression grammar (integers, + - * /, parens), unrelated
+to the sc language. It exists to demonstrate that unilex is reusable
+outside the sc compiler, not to be a language of its own.
+*/
+package arith
+
+import (
+	"fmt"
+	"go/token"
+	"io"
+	"io/ioutil"
+
+	"github.com/davidrjenni/sc/lexer/unilex"
+)
+
+// Type categorizes a token.
+type Type int
+
+const (
+	EOF    Type = iota // end of input
+	Error              // error, value is the error message
+	Number             // integer number
+	Plus               // +
+	Minus              // -
+	Star               // *
+	Slash              // /
+	LParen             // (
+	RParen             // )
+)
+
+// Token represents a token.
+type Token struct {
+	Pos  token.Pos
+	Text string
+	Type Type
+}
+
+func (t Token) String() string {
+	if t.Type == EOF {
+		return "EOF"
+	}
+	return fmt.Sprintf("%q", t.Text)
+}
+
+// Lexer tokenizes an arithmetic expression.
+type Lexer struct {
+	u *unilex.Lexer
+}
+
+// Lex creates a new Lexer for the input source.
+func Lex(filename string, src io.Reader) *Lexer {
+	data, _ := ioutil.ReadAll(src) // best effort; lexing proceeds on whatever was read
+	return &Lexer{u: unilex.New(filename, string(data), lexExpr)}
+}
+
+// FileSet returns the file set used to resolve this lexer's token positions.
+func (l *Lexer) FileSet() *token.FileSet {
+	return l.u.FileSet()
+}
+
+// Next returns the next token of the input.
+func (l *Lexer) Next() Token {
+	it := l.u.Next()
+	return Token{it.Pos, it.Text, Type(it.Type)}
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\r' || r == '\n'
+}
+
+func isDigit(r rune) bool {
+	return '0' <= r && r <= '9'
+}
+
+// lexExpr scans an arithmetic expression.
+func lexExpr(u *unilex.Lexer) unilex.StateFn {
+	u.AcceptRun(isSpace)
+	u.Ignore()
+	switch r := u.Peek(); {
+	case r == unilex.EOF:
+		u.Emit(int(EOF))
+		return nil
+	case isDigit(r):
+		u.ScanNumber()
+		u.Emit(int(Number))
+	case r == '+':
+		u.Accept("+")
+		u.Emit(int(Plus))
+	case r == '-':
+		u.Accept("-")
+		u.Emit(int(Minus))
+	case r == '*':
+		u.Accept("*")
+		u.Emit(int(Star))
+	case r == '/':
+		u.Accept("/")
+		u.Emit(int(Slash))
+	case r == '(':
+		u.Accept("(")
+		u.Emit(int(LParen))
+	case r == ')':
+		u.Accept(")")
+		u.Emit(int(RParen))
+	default:
+		u.Accept(string(r))
+		u.Errorf(int(Error), "unrecognized token %v", string(r))
+	}
+	return lexExpr
+}
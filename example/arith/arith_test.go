@@ -0,0 +1,44 @@
+// Copyright (c) 2014 David R. Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package arith
+
+import (
+	"strings"
+	"testing"
+)
+
+func collect(l *Lexer) (tokens []Token) {
+	for {
+		t := l.Next()
+		tokens = append(tokens, t)
+		if t.Type == EOF {
+			return
+		}
+	}
+}
+
+func TestLex(t *testing.T) {
+	tests := []struct {
+		input string
+		types []Type
+	}{
+		{"1 + 2 * (3 - 4) / 5", []Type{Number, Plus, Number, Star, LParen, Number, Minus, Number, RParen, Slash, Number, EOF}},
+		{"42", []Type{Number, EOF}},
+		{"1 ? 2", []Type{Number, Error, Number, EOF}},
+	}
+	for _, test := range tests {
+		l := Lex("test", strings.NewReader(test.input))
+		tokens := collect(l)
+		if len(tokens) != len(test.types) {
+			t.Errorf("%q: got %d tokens, want %d: %v", test.input, len(tokens), len(test.types), tokens)
+			continue
+		}
+		for i, typ := range test.types {
+			if tokens[i].Type != typ {
+				t.Errorf("%q: token %d: got type %v, want %v", test.input, i, tokens[i].Type, typ)
+			}
+		}
+	}
+}
@@ -0,0 +1,369 @@
+// Copyright (c) 2014 David R. Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Command codegen emits a hand-optimized lexer for package lexer.
+
+The interpreted Lexer drives its StateFn table one rune at a time through
+lexer/unilex, paying for a closure call and a keywords map lookup per
+token. codegen writes a LexFast function that walks the input as a byte
+slice directly and dispatches keywords through a generated switch instead
+of a map. The text of identifier, number and string tokens is still copied
+out of the input into a new string.
+
+codegen is invoked via go:generate from lexer/lexer.go:
+
+	//go:generate go run ./codegen -out=zlexer_generated.go
+
+The interpreted Lexer remains the reference implementation: TestLexFast
+fuzz-checks LexFast against it on random programs.
+*/
+package main
+
+import (
+	"bytes"
+	"flag"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"sort"
+	"text/template"
+)
+
+// keyword pairs the source text of a keyword with the name of its Type constant.
+// This mirrors the keywords table in lexer/lexer.go.
+type keyword struct {
+	Text string
+	Type string
+}
+
+var keywords = []keyword{
+	{"bool", "Bool"},
+	{"else", "Else"},
+	{"false", "False"},
+	{"for", "For"},
+	{"if", "If"},
+	{"int", "Int"},
+	{"true", "True"},
+	{"var", "Var"},
+	{"while", "While"},
+}
+
+var out = flag.String("out", "zlexer_generated.go", "output file")
+
+func main() {
+	flag.Parse()
+	sort.Slice(keywords, func(i, j int) bool { return keywords[i].Text < keywords[j].Text })
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, keywords); err != nil {
+		log.Fatalf("codegen: %v", err)
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatalf("codegen: %v", err)
+	}
+	if err := ioutil.WriteFile(*out, src, 0644); err != nil {
+		log.Fatalf("codegen: %v", err)
+	}
+}
+
+var tmpl = template.Must(template.New("lexer").Parse(tmplSrc))
+
+const tmplSrc = `// Code generated by lexer/codegen from the keywords table. DO NOT EDIT.
+
+package lexer
+
+import (
+	"bytes"
+	"go/token"
+)
+
+// LexFast tokenizes src in one pass and returns the resulting tokens along
+// with the FileSet needed to resolve their Pos. It is a hand-optimized
+// counterpart to Lex: it walks src as a byte slice instead of driving
+// unilex, and dispatches keywords with the switch below instead of a map
+// lookup. The text of identifier, number and string tokens is copied out of
+// src into a new string. Lex remains the reference implementation.
+func LexFast(filename string, src []byte) ([]Token, *token.FileSet) {
+	fset := token.NewFileSet()
+	file := fset.AddFile(filename, -1, len(src))
+	for i, c := range src {
+		if c == '\n' {
+			file.AddLine(i + 1)
+		}
+	}
+
+	f := &fastLexer{src: src, file: file}
+	var toks []Token
+	for {
+		t := f.next()
+		toks = append(toks, t)
+		if t.Type == EOF {
+			return toks, fset
+		}
+	}
+}
+
+// fastLexer holds the state for LexFast.
+type fastLexer struct {
+	src    []byte
+	off    int
+	file   *token.File
+	braces int // nesting of braces
+	parens int // nesting of parens
+}
+
+// pos returns the Pos of the next unread byte.
+func (f *fastLexer) pos() token.Pos {
+	return f.file.Pos(f.off)
+}
+
+// peek returns the byte at src[off+n], or 0 past the end of src.
+func (f *fastLexer) peek(n int) byte {
+	if f.off+n >= len(f.src) {
+		return 0
+	}
+	return f.src[f.off+n]
+}
+
+// advance consumes and returns the next byte.
+func (f *fastLexer) advance() byte {
+	c := f.src[f.off]
+	f.off++
+	return c
+}
+
+// skipSpaceAndComments consumes whitespace, line comments and block comments.
+func (f *fastLexer) skipSpaceAndComments() {
+	for f.off < len(f.src) {
+		switch {
+		case fastIsSpace(f.peek(0)):
+			f.advance()
+		case f.peek(0) == '/' && f.peek(1) == '/':
+			for f.off < len(f.src) && f.peek(0) != '\n' {
+				f.advance()
+			}
+		case f.peek(0) == '/' && f.peek(1) == '*':
+			f.advance()
+			f.advance()
+			for f.off < len(f.src) && !(f.peek(0) == '*' && f.peek(1) == '/') {
+				f.advance()
+			}
+			if f.off < len(f.src) {
+				f.advance()
+				f.advance()
+			}
+		default:
+			return
+		}
+	}
+}
+
+// fastIsSpace reports whether c is an ASCII space character. It is named
+// distinctly from lexer.go's rune-typed isSpace, which this generated file
+// shares a package with, to avoid a redeclaration.
+func fastIsSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// fastIsDigit reports whether c is an ASCII digit.
+func fastIsDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// fastIsIdentStart reports whether c may start an identifier.
+func fastIsIdentStart(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// fastIsIdentPart reports whether c may continue an identifier.
+func fastIsIdentPart(c byte) bool {
+	return fastIsIdentStart(c) || fastIsDigit(c)
+}
+
+// next scans and returns the next token.
+func (f *fastLexer) next() Token {
+	f.skipSpaceAndComments()
+	if f.off >= len(f.src) {
+		return Token{f.pos(), "", EOF}
+	}
+	pos := f.pos()
+	switch c := f.peek(0); {
+	case fastIsIdentStart(c):
+		return f.scanIdent(pos)
+	case fastIsDigit(c):
+		return f.scanNumber(pos)
+	case c == '"':
+		return f.scanString(pos)
+	}
+	return f.scanOperator(pos)
+}
+
+// scanIdent scans an identifier or keyword starting at pos.
+func (f *fastLexer) scanIdent(pos token.Pos) Token {
+	start := f.off
+	for f.off < len(f.src) && fastIsIdentPart(f.peek(0)) {
+		f.advance()
+	}
+	text := string(f.src[start:f.off])
+	if typ, ok := lookupKeyword(text); ok {
+		return Token{pos, text, typ}
+	}
+	return Token{pos, text, Ident}
+}
+
+// scanNumber scans an integer literal starting at pos.
+func (f *fastLexer) scanNumber(pos token.Pos) Token {
+	start := f.off
+	for f.off < len(f.src) && fastIsDigit(f.peek(0)) {
+		f.advance()
+	}
+	return Token{pos, string(f.src[start:f.off]), Number}
+}
+
+// scanString scans a double-quoted string literal, including escape
+// sequences. It mirrors lexString / lexEscape in lexer.go, except that "${"
+// does not start a string interpolation: it is kept as part of the literal.
+func (f *fastLexer) scanString(pos token.Pos) Token {
+	f.advance() // opening quote
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	for {
+		if f.off >= len(f.src) || f.peek(0) == '\n' {
+			return Token{pos, "unterminated string literal", Error}
+		}
+		c := f.advance()
+		switch c {
+		case '"':
+			buf.WriteByte('"')
+			return Token{pos, buf.String(), String}
+		case '\\':
+			escOff := f.off
+			if msg, ok := f.scanEscape(&buf); !ok {
+				// The rune after the backslash, or, for an invalid hex
+				// digit, that digit, which scanHexDigits left unread.
+				errPos := f.file.Pos(escOff)
+				if escOff < len(f.src) && (f.src[escOff] == 'x' || f.src[escOff] == 'u') {
+					errPos = f.pos()
+				}
+				return Token{errPos, msg, Error}
+			}
+		default:
+			buf.WriteByte(c)
+		}
+	}
+}
+
+// scanEscape scans one backslash escape sequence and appends its raw source
+// text to buf. It reports the error message and false on failure.
+func (f *fastLexer) scanEscape(buf *bytes.Buffer) (string, bool) {
+	buf.WriteByte('\\')
+	if f.off >= len(f.src) || f.peek(0) == '\n' {
+		return "unterminated string literal", false
+	}
+	c := f.advance()
+	switch c {
+	case 'n', 't', 'r', '\\', '"':
+		buf.WriteByte(c)
+		return "", true
+	case 'x':
+		buf.WriteByte(c)
+		return f.scanHexDigits(buf, 2)
+	case 'u':
+		buf.WriteByte(c)
+		return f.scanHexDigits(buf, 4)
+	}
+	return "invalid escape sequence \\" + string(c), false
+}
+
+// scanHexDigits scans n hexadecimal digits of a \x or \u escape sequence.
+func (f *fastLexer) scanHexDigits(buf *bytes.Buffer, n int) (string, bool) {
+	for i := 0; i < n; i++ {
+		if f.off >= len(f.src) || !isHexDigit(rune(f.peek(0))) {
+			return "invalid hex digit in escape sequence", false
+		}
+		buf.WriteByte(f.advance())
+	}
+	return "", true
+}
+
+// scanOperator scans an operator or delimiter starting at pos.
+func (f *fastLexer) scanOperator(pos token.Pos) Token {
+	c := f.advance()
+	switch c {
+	case '*':
+		return Token{pos, "*", Multiply}
+	case '/':
+		return Token{pos, "/", Divide}
+	case '+':
+		return Token{pos, "+", Plus}
+	case '-':
+		return Token{pos, "-", Minus}
+	case '=':
+		return f.ifNext('=', pos, "=", Equal, Assign)
+	case '<':
+		return f.ifNext('=', pos, "<", LessOrEqual, Less)
+	case '>':
+		return f.ifNext('=', pos, ">", GreaterOrEqual, Greater)
+	case '!':
+		return f.ifNext('=', pos, "!", NotEqual, Not)
+	case '&':
+		return f.expect('&', pos, "&", And, "expected && operator")
+	case '|':
+		return f.expect('|', pos, "|", Or, "expected || operator")
+	case '{':
+		f.braces++
+		return Token{pos, "{", LeftBrace}
+	case '}':
+		f.braces--
+		if f.braces < 0 {
+			return Token{pos, "unexpected }", Error}
+		}
+		return Token{pos, "}", RightBrace}
+	case '(':
+		f.parens++
+		return Token{pos, "(", LeftParen}
+	case ')':
+		f.parens--
+		if f.parens < 0 {
+			return Token{pos, "unexpected )", Error}
+		}
+		return Token{pos, ")", RightParen}
+	}
+	return Token{pos, "unrecognized token " + string(c), Error}
+}
+
+// ifNext returns a t1 token if the next byte is r, otherwise a t2 token of
+// just text.
+func (f *fastLexer) ifNext(r byte, pos token.Pos, text string, t1, t2 Type) Token {
+	if f.peek(0) == r {
+		f.advance()
+		return Token{pos, text + string(r), t1}
+	}
+	return Token{pos, text, t2}
+}
+
+// expect returns a token of type typ if the next byte is r, otherwise an
+// Error token carrying err.
+func (f *fastLexer) expect(r byte, pos token.Pos, text string, typ Type, err string) Token {
+	if f.peek(0) == r {
+		f.advance()
+		return Token{pos, text + string(r), typ}
+	}
+	return Token{pos, err, Error}
+}
+
+// lookupKeyword dispatches a scanned identifier to its keyword Type via a
+// generated switch, avoiding the map lookup lexIdent performs.
+func lookupKeyword(text string) (Type, bool) {
+	switch text {
+	{{- range .}}
+	case "{{.Text}}":
+		return {{.Type}}, true
+	{{- end}}
+	}
+	return 0, false
+}
+`
@@ -3,13 +3,19 @@
 // license that can be found in the LICENSE file.
 
 //go:generate stringer -type Type
+//go:generate go run ./codegen -out=zlexer_generated.go
 
 package lexer
 
 import (
+	"bytes"
 	"fmt"
+	"go/token"
 	"io"
-	"text/scanner"
+	"io/ioutil"
+	"strings"
+
+	"github.com/davidrjenni/sc/lexer/unilex"
 )
 
 // Type categorizes a token.
@@ -22,9 +28,11 @@ const (
 	// Literals
 	False  // false
 	Number // integer number
+	String // string literal
 	True   // true
 	// Keywords
 	Else  // else
+	For   // for
 	If    // if
 	Var   // var
 	While // while
@@ -54,6 +62,9 @@ const (
 	RightParen // )
 	LeftBrace  // {
 	RightBrace // }
+	// String interpolation delimiters
+	InterpStart // ${
+	InterpEnd   // } closing a string interpolation
 )
 
 // All the keywords.
@@ -61,6 +72,7 @@ var keywords = map[string]Type{
 	"bool":  Bool,
 	"else":  Else,
 	"false": False,
+	"for":   For,
 	"if":    If,
 	"int":   Int,
 	"true":  True,
@@ -70,9 +82,9 @@ var keywords = map[string]Type{
 
 // Token represents a token.
 type Token struct {
-	Pos  scanner.Position // position in the input string
-	Text string           // text of this token
-	Type Type             // tpye of this token
+	Pos  token.Pos // position of the first character of this token
+	Text string    // text of this token
+	Type Type      // tpye of this token
 }
 
 func (t Token) String() string {
@@ -83,166 +95,462 @@ func (t Token) String() string {
 		return t.Text
 	}
 	if len(t.Text) > 10 {
-		return fmt.Sprintf("%s:%d:%d %.10q...", t.Pos.Filename, t.Pos.Line, t.Pos.Column, t.Text)
+		return fmt.Sprintf("%.10q...", t.Text)
+	}
+	return fmt.Sprintf("%q", t.Text)
+}
+
+// LexError represents a lexical error together with the position it
+// occurred at, so that it can be rendered as a diagnostic independently of
+// the Lexer or its FileSet.
+type LexError struct {
+	Pos token.Position
+	Msg string
+}
+
+func (e LexError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+// PrintError writes a clang-style diagnostic for e to w: the error
+// message, the offending source line, and a caret under the exact column.
+func PrintError(w io.Writer, src []byte, e LexError) {
+	fmt.Fprintf(w, "%s: %s\n", e.Pos, e.Msg)
+	line := sourceLine(src, e.Pos.Line)
+	if line == "" {
+		return
 	}
-	return fmt.Sprintf("%s:%d:%d %q", t.Pos.Filename, t.Pos.Line, t.Pos.Column, t.Text)
+	fmt.Fprintln(w, line)
+	col := e.Pos.Column
+	if col < 1 {
+		col = 1
+	}
+	fmt.Fprintln(w, strings.Repeat(" ", col-1)+"^")
 }
 
-// Lexer represents the lexical analyser.
+// sourceLine returns the n-th (1-based) line of src, or "" if n is out of range.
+func sourceLine(src []byte, n int) string {
+	lines := bytes.Split(src, []byte("\n"))
+	if n < 1 || n > len(lines) {
+		return ""
+	}
+	return string(lines[n-1])
+}
+
+// lexState is the sc-specific state lexSource and friends keep in the
+// generic unilex.Lexer's Extra field: the brace/paren nesting that was
+// previously tracked directly on Lexer.
+type lexState struct {
+	braces int // nesting of braces
+	parens int // nesting of parens
+}
+
+// Lexer represents the lexical analyser. It is a thin, sc-specific shell
+// around a unilex.Lexer: this package supplies the token set and the
+// StateFns, unilex supplies the scanning primitives and position tracking.
 type Lexer struct {
-	Tokens  chan Token
-	scanner scanner.Scanner
-	braces  int // nesting of braces
-	parens  int // nesting of parens
+	filename string
+	src      strings.Builder
+	u        *unilex.Lexer
+	consumed int // real (non-EOF) tokens drawn from u so far; replayed by rebuild
+	errs     []LexError
+	peeked   *Token
 }
 
-// Lex creates a new lexer for the input source.
+// Lex creates a new lexer for the input source. Positions are tracked with
+// a go/token.FileSet private to this Lexer; call FileSet to share it with
+// later passes (parser, type checker, codegen) that need to resolve the
+// Pos of the tokens Lex produces.
 func Lex(filename string, src io.Reader) *Lexer {
-	l := &Lexer{Tokens: make(chan Token)}
-	l.scanner.Init(src)
-	l.scanner.Filename = filename
-	go l.run()
+	l := &Lexer{filename: filename}
+	l.Feed(src)
 	return l
 }
 
-// emit emits a token to the channel of tokens.
-func (l *Lexer) emit(pos scanner.Position, text string, typ Type) {
-	l.Tokens <- Token{pos, text, typ}
+// Feed appends more source to l and rebuilds the underlying unilex.Lexer
+// over everything accumulated so far, replaying the tokens already drawn
+// to restore brace/paren nesting and mode-stack state exactly as it was.
+// This is the only way to grow a lexer's input, since a go/token.File's
+// size is fixed at creation; it lets Lex accept successive io.Readers
+// while keeping position and nesting continuous across them, which is
+// what an incremental consumer like a REPL needs: call Feed for each
+// line read, then Next/Peek until AtStatementBoundary says whether the
+// submission is complete or needs a continuation line.
+//
+// A token already returned by Peek but not yet consumed by Next survives
+// Feed untouched: it was already counted among the tokens replayed above,
+// and its Pos remains valid because every rebuilt unilex.Lexer starts a
+// fresh single-file FileSet with the same base, so the same offset always
+// resolves to the same Pos.
+//
+// Feed invalidates the FileSet returned by a previous call to FileSet;
+// resolve a Token's Pos before feeding more input, not after.
+func (l *Lexer) Feed(src io.Reader) error {
+	data, err := ioutil.ReadAll(src) // best effort; lexing proceeds on whatever was read
+	l.src.Write(data)
+
+	u := unilex.New(l.filename, l.src.String(), lexSource)
+	u.Extra = &lexState{}
+	for i := 0; i < l.consumed; i++ {
+		u.Next()
+	}
+	l.u = u
+	return err
 }
 
-// emitHere emits the current token to the channel of tokens.
-func (l *Lexer) emitHere(typ Type) {
-	l.Tokens <- Token{l.scanner.Position, l.scanner.TokenText(), typ}
+// FileSet returns the file set used to resolve this lexer's token positions.
+func (l *Lexer) FileSet() *token.FileSet {
+	return l.u.FileSet()
 }
 
-// emitIfNext emits a token of type t1 if r matches the next rune. Otherwise a token of type t2 is emitted.
-func (l *Lexer) emitIfNext(r rune, t1, t2 Type) {
-	if l.scanner.Peek() == r {
-		pos := l.scanner.Position
-		text := l.scanner.TokenText()
-		l.scanner.Scan()
-		text += l.scanner.TokenText()
-		l.emit(pos, text, t1)
-	} else {
-		l.emit(l.scanner.Position, l.scanner.TokenText(), t2)
+// ErrorList returns the lexical errors emitted so far, in the order they occurred.
+func (l *Lexer) ErrorList() []LexError {
+	return l.errs
+}
+
+// AtStatementBoundary reports whether l is between statements: no open
+// braces or parens, and not in the middle of a string interpolation. A
+// REPL calls this after draining the tokens of a submitted line to decide
+// whether it was a complete statement or needs a continuation line.
+func (l *Lexer) AtStatementBoundary() bool {
+	ls := l.u.Extra.(*lexState)
+	return ls.braces == 0 && ls.parens == 0 && l.u.CurrentMode().Name == ""
+}
+
+// Next returns the next token of the input.
+func (l *Lexer) Next() Token {
+	if l.peeked != nil {
+		t := *l.peeked
+		l.peeked = nil
+		return t
 	}
+	return l.next()
 }
 
-// error emits an error token.
-func (l *Lexer) errorf(format string, args ...interface{}) {
-	l.emit(l.scanner.Position, fmt.Sprintf(format, args...), Error)
+// Peek returns the next token of the input without consuming it.
+func (l *Lexer) Peek() Token {
+	if l.peeked == nil {
+		t := l.next()
+		l.peeked = &t
+	}
+	return *l.peeked
 }
 
-// expect emits an token if r matches the next rune. Otherwise an error is emitted.
-func (l *Lexer) expect(r rune, typ Type, err string) {
-	if l.scanner.Peek() == r {
-		pos := l.scanner.Position
-		text := l.scanner.TokenText()
-		l.scanner.Scan()
-		text += l.scanner.TokenText()
-		l.emit(pos, text, typ)
-	} else {
-		l.errorf(err)
+// Tokens returns a channel of tokens, for callers that prefer to range
+// over the token stream instead of calling Next directly.
+func (l *Lexer) Tokens() <-chan Token {
+	ch := make(chan Token)
+	go func() {
+		defer close(ch)
+		for {
+			t := l.Next()
+			ch <- t
+			if t.Type == EOF {
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// next pulls the next Item out of the underlying unilex.Lexer, converts it
+// to a Token, and records it in errs if it is an Error token. EOF is not
+// counted towards consumed: it carries no input of its own, and future
+// Feed calls may turn what is EOF now into a real token.
+func (l *Lexer) next() Token {
+	t := toToken(l.u.Next())
+	if t.Type != EOF {
+		l.consumed++
 	}
+	if t.Type == Error {
+		l.errs = append(l.errs, LexError{Pos: l.u.FileSet().Position(t.Pos), Msg: t.Text})
+	}
+	return t
+}
+
+// toToken converts a unilex.Item, whose Type is this package's Type in
+// disguise, to a Token.
+func toToken(it unilex.Item) Token {
+	return Token{it.Pos, it.Text, Type(it.Type)}
+}
+
+// isSpace reports whether r is ASCII whitespace.
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\r' || r == '\n'
+}
+
+// isDigit reports whether r is an ASCII digit.
+func isDigit(r rune) bool {
+	return '0' <= r && r <= '9'
+}
+
+// isIdentStart reports whether r may start an identifier.
+func isIdentStart(r rune) bool {
+	return r == '_' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z'
+}
+
+// isHexDigit reports whether r is an ASCII hexadecimal digit.
+func isHexDigit(r rune) bool {
+	return '0' <= r && r <= '9' || 'a' <= r && r <= 'f' || 'A' <= r && r <= 'F'
 }
 
-// run starts the lexer.
-func (l *Lexer) run() {
-	for state := lexSource; state != nil; {
-		state = state(l)
+// skipSpaceAndComments consumes whitespace, line comments and block comments.
+func skipSpaceAndComments(u *unilex.Lexer) {
+	for {
+		switch {
+		case isSpace(u.Peek()):
+			u.AcceptRun(isSpace)
+			u.Ignore()
+		case u.Peek() == '/' && u.PeekN(1) == '/':
+			u.AcceptRun(func(r rune) bool { return r != '\n' && r != unilex.EOF })
+			u.Ignore()
+		case u.Peek() == '/' && u.PeekN(1) == '*':
+			u.Accept("/")
+			u.Accept("*")
+			for u.Peek() != unilex.EOF && !(u.Peek() == '*' && u.PeekN(1) == '/') {
+				u.Accept(string(u.Peek()))
+			}
+			if u.Peek() != unilex.EOF {
+				u.Accept("*")
+				u.Accept("/")
+			}
+			u.Ignore()
+		default:
+			return
+		}
 	}
-	close(l.Tokens)
 }
 
-// stateFn is a state of the lexer. It is a function that returns the next state.
-type stateFn func(*Lexer) stateFn
+// interpModeName names the mode entered for the expression inside a string
+// interpolation "${ ... }" (see lexInterpStart) and left on the matching
+// '}', which resumes the string literal that pushed it. It is a plain
+// constant, rather than a package-level unilex.Mode value, because a Mode
+// naming lexInterpExpr as its Enter state would depend on currentDispatch,
+// which depends back on the Mode itself: a genuine initialization cycle.
+// The unilex.Mode value is instead built at the PushMode call site.
+const interpModeName = "StringInterp"
 
-// lexSource scans the source.
-func lexSource(l *Lexer) stateFn {
-	switch l.scanner.Scan() {
-	case scanner.EOF:
-		l.emitHere(EOF)
+// currentDispatch returns the state a token-scanning StateFn (lexIdent,
+// lexNumber, lexOperator, ...) should return to once it has emitted its
+// token: lexInterpExpr while inside a string interpolation's "${ ... }",
+// lexSource otherwise.
+func currentDispatch(u *unilex.Lexer) unilex.StateFn {
+	if u.CurrentMode().Name == interpModeName {
+		return lexInterpExpr
+	}
+	return lexSource
+}
+
+// lexSource scans the source. It is the Root mode's dispatcher.
+func lexSource(u *unilex.Lexer) unilex.StateFn {
+	skipSpaceAndComments(u)
+	switch r := u.Peek(); {
+	case r == unilex.EOF:
+		u.Emit(int(EOF))
 		return nil
-	case scanner.Ident:
+	case isIdentStart(r):
 		return lexIdent
-	case scanner.Int:
-		l.emitHere(Number)
+	case isDigit(r):
+		return lexNumber
+	case r == '"':
+		return lexString
+	}
+	return lexOperator
+}
+
+// lexInterpExpr scans one token of the expression inside a string
+// interpolation "${ ... }". It shares lexSource's dispatch, except that
+// the first '}' ends the interpolation instead of being a RightBrace,
+// resuming the string literal via PopMode. A '{' inside the expression is
+// lexed as a LeftBrace and is not matched against that '}'.
+func lexInterpExpr(u *unilex.Lexer) unilex.StateFn {
+	skipSpaceAndComments(u)
+	switch r := u.Peek(); {
+	case r == unilex.EOF:
+		u.Errorf(int(Error), "unterminated string interpolation")
+		return nil
+	case r == '}':
+		u.Accept("}")
+		u.Emit(int(InterpEnd))
+		return u.PopMode()
+	}
+	return lexSource(u)
+}
+
+// lexIdent scans an alphanumeric identifier.
+func lexIdent(u *unilex.Lexer) unilex.StateFn {
+	u.ScanIdent()
+	if typ, ok := keywords[u.Pending()]; ok {
+		u.Emit(int(typ))
+	} else {
+		u.Emit(int(Ident))
+	}
+	return currentDispatch(u)
+}
+
+// lexNumber scans an integer literal.
+func lexNumber(u *unilex.Lexer) unilex.StateFn {
+	u.ScanNumber()
+	u.Emit(int(Number))
+	return currentDispatch(u)
+}
+
+// lexOperator scans an operator or delimiter and keeps track of brace and
+// paren nesting.
+func lexOperator(u *unilex.Lexer) unilex.StateFn {
+	ls := u.Extra.(*lexState)
+	r := u.Peek()
+	u.Accept(string(r))
+	switch r {
 	case '*':
-		l.emitHere(Multiply)
+		u.Emit(int(Multiply))
 	case '/':
-		l.emitHere(Divide)
+		u.Emit(int(Divide))
 	case '+':
-		l.emitHere(Plus)
+		u.Emit(int(Plus))
 	case '-':
-		l.emitHere(Minus)
+		u.Emit(int(Minus))
 	case '=':
-		l.emitIfNext('=', Equal, Assign)
+		emitIfNext(u, '=', Equal, Assign)
 	case '<':
-		l.emitIfNext('=', LessOrEqual, Less)
+		emitIfNext(u, '=', LessOrEqual, Less)
 	case '>':
-		l.emitIfNext('=', GreaterOrEqual, Greater)
+		emitIfNext(u, '=', GreaterOrEqual, Greater)
 	case '!':
-		l.emitIfNext('=', NotEqual, Not)
+		emitIfNext(u, '=', NotEqual, Not)
 	case '&':
-		l.expect('&', And, "expected && operator")
+		expect(u, '&', And, "expected && operator")
 	case '|':
-		l.expect('|', Or, "expected || operator")
+		expect(u, '|', Or, "expected || operator")
 	case '{':
-		return lexLeftBrace
+		ls.braces++
+		u.Emit(int(LeftBrace))
 	case '}':
-		return lexRightBrace
+		ls.braces--
+		if ls.braces < 0 {
+			u.Errorf(int(Error), "unexpected }")
+		} else {
+			u.Emit(int(RightBrace))
+		}
 	case '(':
-		return lexLeftParen
+		ls.parens++
+		u.Emit(int(LeftParen))
 	case ')':
-		return lexRightParen
+		ls.parens--
+		if ls.parens < 0 {
+			u.Errorf(int(Error), "unexpected )")
+		} else {
+			u.Emit(int(RightParen))
+		}
 	default:
-		l.errorf("unrecognized token %v", l.scanner.TokenText())
+		u.Errorf(int(Error), "unrecognized token %v", string(r))
 	}
-	return lexSource
+	return currentDispatch(u)
 }
 
-// lexIdent scans an alphanumeric identifier.
-func lexIdent(l *Lexer) stateFn {
-	typ, ok := keywords[l.scanner.TokenText()]
-	if !ok {
-		typ = Ident
+// emitIfNext emits a token of type t1 if r matches the next rune. Otherwise a token of type t2 is emitted.
+func emitIfNext(u *unilex.Lexer, r rune, t1, t2 Type) {
+	if u.Accept(string(r)) {
+		u.Emit(int(t1))
+	} else {
+		u.Emit(int(t2))
 	}
-	l.emitHere(typ)
-	return lexSource
 }
 
-// lexLeftBrace scans a left brace and keeps track of the nesting.
-func lexLeftBrace(l *Lexer) stateFn {
-	l.braces++
-	l.emitHere(LeftBrace)
-	return lexSource
+// expect emits a token of type typ if r matches the next rune. Otherwise an error is emitted.
+func expect(u *unilex.Lexer, r rune, typ Type, err string) {
+	if u.Accept(string(r)) {
+		u.Emit(int(typ))
+	} else {
+		u.Errorf(int(Error), "%s", err)
+	}
 }
 
-// lexRightBrace scans a right brace and keeps track of the nesting.
-func lexRightBrace(l *Lexer) stateFn {
-	l.braces--
-	if l.braces < 0 {
-		l.errorf("unexpected }")
-	} else {
-		l.emitHere(RightBrace)
+// lexString scans a double-quoted string literal. It is entered from
+// lexSource or lexInterpExpr on '"', which has not yet been consumed.
+func lexString(u *unilex.Lexer) unilex.StateFn {
+	u.Accept(`"`) // opening quote; kept in Text since start is not reset
+	return lexStringBody(u)
+}
+
+// lexStringBody scans the literal text of a string, including escape
+// sequences, up to the next "${" interpolation, the closing '"', or an
+// error. It is also the state execution resumes in, via PopMode, once a
+// "${ ... }" interpolation's matching '}' has been scanned.
+func lexStringBody(u *unilex.Lexer) unilex.StateFn {
+	for {
+		switch r := u.Peek(); r {
+		case unilex.EOF, '\n':
+			u.Errorf(int(Error), "unterminated string literal")
+			return currentDispatch(u)
+		case '"':
+			u.Accept(`"`)
+			u.Emit(int(String))
+			return currentDispatch(u)
+		case '$':
+			if u.PeekN(1) == '{' {
+				u.Emit(int(String))
+				return lexInterpStart
+			}
+			u.Accept("$")
+		case '\\':
+			u.Accept(`\`)
+			if !lexEscape(u) {
+				return currentDispatch(u)
+			}
+		default:
+			u.Accept(string(r))
+		}
 	}
-	return lexSource
 }
 
-// lexLeftParen scans a left paren and keeps track of the nesting.
-func lexLeftParen(l *Lexer) stateFn {
-	l.parens++
-	l.emitHere(LeftParen)
-	return lexSource
+// lexInterpStart scans the "${" that opens a string interpolation and
+// pushes the mode that lexes its expression. It runs as its own StateFn,
+// separately from the Emit(String) of the string chunk preceding it in
+// lexStringBody, since a StateFn may only Emit once per invocation.
+func lexInterpStart(u *unilex.Lexer) unilex.StateFn {
+	u.Accept("$")
+	u.Accept("{")
+	u.Emit(int(InterpStart))
+	return u.PushMode(unilex.Mode{Name: interpModeName, Enter: lexInterpExpr}, lexStringBody)
 }
 
-// lexRightParen scans a right paren and keeps track of the nesting.
-func lexRightParen(l *Lexer) stateFn {
-	l.parens--
-	if l.parens < 0 {
-		l.errorf("unexpected )")
-	} else {
-		l.emitHere(RightParen)
+// lexEscape scans a backslash escape sequence inside a string literal. It
+// is entered after the backslash has been consumed, and reports whether
+// the escape was valid; on failure it has already emitted an Error item.
+func lexEscape(u *unilex.Lexer) bool {
+	switch r := u.Peek(); r {
+	case 'n', 't', 'r', '\\', '"':
+		u.Accept(string(r))
+		return true
+	case 'x':
+		u.Accept("x")
+		return lexHexDigits(u, 2)
+	case 'u':
+		u.Accept("u")
+		return lexHexDigits(u, 4)
+	case unilex.EOF, '\n':
+		u.ErrorfAt(u.Pos(), int(Error), "unterminated string literal")
+		return false
+	default:
+		errPos := u.Pos()
+		u.Accept(string(r))
+		u.ErrorfAt(errPos, int(Error), "invalid escape sequence \\%c", r)
+		return false
 	}
-	return lexSource
+}
+
+// lexHexDigits scans n hexadecimal digits of a \x or \u escape sequence.
+// It reports whether all n digits were valid hex digits.
+func lexHexDigits(u *unilex.Lexer, n int) bool {
+	for i := 0; i < n; i++ {
+		errPos := u.Pos()
+		r := u.Peek()
+		if !isHexDigit(r) {
+			u.ErrorfAt(errPos, int(Error), "invalid hex digit in escape sequence")
+			return false
+		}
+		u.Accept(string(r))
+	}
+	return true
 }
@@ -0,0 +1,111 @@
+// Copyright (c) 2014 David R. Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build generated
+
+// This file exercises LexFast, which is produced by lexer/codegen and is
+// not checked in (see zlexer_generated.go in the go:generate directive in
+// lexer.go). It is built only when the "generated" tag is passed, so that
+// go build/test work before `go generate ./...` has been run, exactly as
+// they already do for the stringer-generated Type.String.
+
+package lexer
+
+import (
+	"fmt"
+	"go/token"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// fastTestPrograms are fixed programs that exercise every token kind,
+// including the error paths.
+var fastTestPrograms = []string{
+	``,
+	`var a = 1`,
+	`if (a == b && b != 0) { a = a + b * 2 - 1 / b } else { a = a + 1 }`,
+	`"hello \"world\"\n" "\x41é"`,
+	`& | } ) ? { ( "unterminated`,
+}
+
+// TestLexFast checks that LexFast agrees with the interpreted Lex on the
+// fixed programs above and on randomly generated ones.
+func TestLexFast(t *testing.T) {
+	for _, src := range fastTestPrograms {
+		checkLexFast(t, src)
+	}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		checkLexFast(t, randomProgram(r))
+	}
+}
+
+func checkLexFast(t *testing.T, src string) {
+	t.Helper()
+	l := Lex("fuzz", strings.NewReader(src))
+	want := collectAll(l)
+	wantFset := l.FileSet()
+	got, gotFset := LexFast("fuzz", []byte(src))
+	if !equalResolved(want, wantFset, got, gotFset) {
+		t.Errorf("LexFast(%q) = %v, want %v", src, got, want)
+	}
+}
+
+// equalResolved compares two independently produced token slices by Type,
+// Text and resolved Line/Column, since a and b carry Pos values from
+// different FileSets and are not directly comparable.
+func equalResolved(a []Token, aFset *token.FileSet, b []Token, bFset *token.FileSet) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Type != b[i].Type || a[i].Text != b[i].Text {
+			return false
+		}
+		pa, pb := aFset.Position(a[i].Pos), bFset.Position(b[i].Pos)
+		if pa.Line != pb.Line || pa.Column != pb.Column {
+			return false
+		}
+	}
+	return true
+}
+
+// collectAll drains every token of l, including the trailing EOF.
+func collectAll(l *Lexer) (tokens []Token) {
+	for {
+		t := l.Next()
+		tokens = append(tokens, t)
+		if t.Type == EOF {
+			return
+		}
+	}
+}
+
+// symbols are the building blocks randomProgram assembles test input from.
+var symbols = []string{
+	"a", "b", "x", "1", "42", "if", "else", "while", "var", "true", "false", "bool", "int",
+	"=", "==", "!=", "<", "<=", ">", ">=", "+", "-", "*", "/", "&&", "||", "!",
+	"{", "}", "(", ")", `"hi"`, `"a\nb"`,
+}
+
+// randomProgram builds a random sequence of tokens and whitespace, to
+// fuzz-check LexFast against Lex beyond the fixed programs above.
+func randomProgram(r *rand.Rand) string {
+	var b strings.Builder
+	for i, n := 0, r.Intn(20); i < n; i++ {
+		fmt.Fprint(&b, symbols[r.Intn(len(symbols))])
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+// BenchmarkLexFast measures scanning benchSource with the generated lexer,
+// for comparison against BenchmarkLex.
+func BenchmarkLexFast(b *testing.B) {
+	src := []byte(benchSource)
+	for i := 0; i < b.N; i++ {
+		_, _ = LexFast("bench", src)
+	}
+}
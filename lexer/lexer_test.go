@@ -5,21 +5,21 @@
 package lexer
 
 import (
+	"go/token"
 	"strings"
 	"testing"
-	"text/scanner"
 )
 
 type lexerTest struct {
 	name   string
 	input  string
-	tokens []Token
+	tokens []wantToken
 }
 
 // All the lexer tests.
 var lexerTests = []lexerTest{
-	{"empty", "", []Token{newToken(EOF, "", 0, 0)}},
-	{"identifiers", "a foo _a _1 a1 ifelse", []Token{
+	{"empty", "", []wantToken{newToken(EOF, "", 1, 1)}},
+	{"identifiers", "a foo _a _1 a1 ifelse", []wantToken{
 		newToken(Ident, "a", 1, 1),
 		newToken(Ident, "foo", 1, 3),
 		newToken(Ident, "_a", 1, 7),
@@ -27,13 +27,27 @@ var lexerTests = []lexerTest{
 		newToken(Ident, "a1", 1, 13),
 		newToken(Ident, "ifelse", 1, 16),
 		newToken(EOF, "", 1, 22)}},
-	{"literals", "42 35 true false", []Token{
+	{"literals", "42 35 true false", []wantToken{
 		newToken(Number, "42", 1, 1),
 		newToken(Number, "35", 1, 4),
 		newToken(True, "true", 1, 7),
 		newToken(False, "false", 1, 12),
 		newToken(EOF, "", 1, 17)}},
-	{"keywords", "bool else false if int true var for", []Token{
+	{"strings", "\"hi\" \"a\\nb\" \"\\x41\\u00e9\"", []wantToken{
+		newToken(String, "\"hi\"", 1, 1),
+		newToken(String, "\"a\\nb\"", 1, 6),
+		newToken(String, "\"\\x41\\u00e9\"", 1, 13),
+		newToken(EOF, "", 1, 25)}},
+	{"string invalid escape", `"a\z`, []wantToken{
+		newToken(Error, `invalid escape sequence \z`, 1, 4),
+		newToken(EOF, "", 1, 5)}},
+	{"unterminated string", `"abc`, []wantToken{
+		newToken(Error, "unterminated string literal", 1, 1),
+		newToken(EOF, "", 1, 5)}},
+	{"unterminated string escape", `"a\`, []wantToken{
+		newToken(Error, "unterminated string literal", 1, 4),
+		newToken(EOF, "", 1, 4)}},
+	{"keywords", "bool else false if int true var for", []wantToken{
 		newToken(Bool, "bool", 1, 1),
 		newToken(Else, "else", 1, 6),
 		newToken(False, "false", 1, 11),
@@ -43,7 +57,7 @@ var lexerTests = []lexerTest{
 		newToken(Var, "var", 1, 29),
 		newToken(For, "for", 1, 33),
 		newToken(EOF, "", 1, 36)}},
-	{"operators", "= * / + - < <= == != >= > && || !", []Token{
+	{"operators", "= * / + - < <= == != >= > && || !", []wantToken{
 		newToken(Assign, "=", 1, 1),
 		newToken(Multiply, "*", 1, 3),
 		newToken(Divide, "/", 1, 5),
@@ -59,45 +73,101 @@ var lexerTests = []lexerTest{
 		newToken(Or, "||", 1, 30),
 		newToken(Not, "!", 1, 33),
 		newToken(EOF, "", 1, 34)}},
-	{"delimiters", "{}()", []Token{
+	{"delimiters", "{}()", []wantToken{
 		newToken(LeftBrace, "{", 1, 1),
 		newToken(RightBrace, "}", 1, 2),
 		newToken(LeftParen, "(", 1, 3),
 		newToken(RightParen, ")", 1, 4),
 		newToken(EOF, "", 1, 5)}},
-	{"line comment", "a // comment\nb", []Token{
+	{"line comment", "a // comment\nb", []wantToken{
 		newToken(Ident, "a", 1, 1),
 		newToken(Ident, "b", 2, 1),
 		newToken(EOF, "", 2, 2)}},
-	{"block comment", "a /* x \n x */ b c/* x */d", []Token{
+	{"block comment", "a /* x \n x */ b c/* x */d", []wantToken{
 		newToken(Ident, "a", 1, 1),
 		newToken(Ident, "b", 2, 7),
 		newToken(Ident, "c", 2, 9),
 		newToken(Ident, "d", 2, 17),
 		newToken(EOF, "", 2, 18)}},
-	{"errors", "& | } ) ?", []Token{
+	{"errors", "& | } ) ?", []wantToken{
 		newToken(Error, "expected && operator", 1, 1),
 		newToken(Error, "expected || operator", 1, 3),
 		newToken(Error, "unexpected }", 1, 5),
 		newToken(Error, "unexpected )", 1, 7),
 		newToken(Error, "unrecognized token ?", 1, 9),
 		newToken(EOF, "", 1, 10)}},
+	{"string interpolation", `"a ${x} b"`, []wantToken{
+		newToken(String, `"a `, 1, 1),
+		newToken(InterpStart, "${", 1, 4),
+		newToken(Ident, "x", 1, 6),
+		newToken(InterpEnd, "}", 1, 7),
+		newToken(String, ` b"`, 1, 8),
+		newToken(EOF, "", 1, 11)}},
+}
+
+// TestLexNestedInterpolation checks that a string interpolation whose
+// expression itself contains an interpolated string is lexed correctly,
+// i.e. that the mode stack PushMode/PopMode maintain unwinds in the right
+// order rather than just one level deep.
+func TestLexNestedInterpolation(t *testing.T) {
+	test := lexerTest{
+		name:  "nested interpolation",
+		input: `"a ${1 + "b ${2} c"} d"`,
+		tokens: []wantToken{
+			newToken(String, `"a `, 1, 1),
+			newToken(InterpStart, "${", 1, 4),
+			newToken(Number, "1", 1, 6),
+			newToken(Plus, "+", 1, 8),
+			newToken(String, `"b `, 1, 10),
+			newToken(InterpStart, "${", 1, 13),
+			newToken(Number, "2", 1, 15),
+			newToken(InterpEnd, "}", 1, 16),
+			newToken(String, ` c"`, 1, 17),
+			newToken(InterpEnd, "}", 1, 20),
+			newToken(String, ` d"`, 1, 21),
+			newToken(EOF, "", 1, 24)},
+	}
+	tokens, fset := collect(&test)
+	if !equal(tokens, fset, test.tokens) {
+		t.Errorf("%s: got '%v' expected '%v'", test.name, tokens, test.tokens)
+	}
+}
+
+// TestFeedPreservesPeek checks that a token already returned by Peek but
+// not yet consumed by Next survives a Feed call intact, instead of being
+// silently dropped by the rebuild that Feed performs to grow the input.
+func TestFeedPreservesPeek(t *testing.T) {
+	l := Lex("test", strings.NewReader("a b"))
+	peeked := l.Peek()
+	l.Feed(strings.NewReader(" c"))
+	if got := l.Next(); got.Text != peeked.Text || got.Type != peeked.Type {
+		t.Fatalf("Next() after Feed = %v, want the peeked token %v", got, peeked)
+	}
+	want := []string{"b", "c", ""}
+	for _, w := range want {
+		if got := l.Next(); got.Text != w {
+			t.Errorf("Next() = %q, want %q", got.Text, w)
+		}
+	}
 }
 
 // TestLex runs all lexer tests.
 func TestLex(t *testing.T) {
 	for _, test := range lexerTests {
-		tokens := collect(&test)
-		if !equal(tokens, test.tokens) {
+		tokens, fset := collect(&test)
+		if !equal(tokens, fset, test.tokens) {
 			t.Errorf("%s: got '%v' expected '%v'", test.name, tokens, test.tokens)
 		}
 	}
 }
 
-// collect scans all tokens of a lexer test and puts them into a slice.
-func collect(test *lexerTest) (tokens []Token) {
+// collect scans all tokens of a lexer test and puts them into a slice,
+// along with the FileSet needed to resolve their positions.
+func collect(test *lexerTest) (tokens []Token, fset *token.FileSet) {
 	l := Lex(test.name, strings.NewReader(test.input))
-	for t := range l.Tokens {
+	fset = l.FileSet()
+	for {
+		t := l.Next()
 		tokens = append(tokens, t)
 		if t.Type == EOF {
 			break
@@ -106,26 +176,71 @@ func collect(test *lexerTest) (tokens []Token) {
 	return
 }
 
-// equal checks whether two slices of tokens are the same.
-func equal(t1, t2 []Token) bool {
-	if len(t1) != len(t2) {
+// equal checks whether tokens, resolved through fset, match the expected tokens.
+func equal(tokens []Token, fset *token.FileSet, want []wantToken) bool {
+	if len(tokens) != len(want) {
 		return false
 	}
-	for i := range t1 {
-		if t1[i].Type != t2[i].Type || t1[i].Text != t2[i].Text {
+	for i := range tokens {
+		if tokens[i].Type != want[i].Type || tokens[i].Text != want[i].Text {
 			return false
 		}
-		if t1[i].Pos.Line != t2[i].Pos.Line || t1[i].Pos.Column != t2[i].Pos.Column {
+		p := fset.Position(tokens[i].Pos)
+		if p.Line != want[i].Line || p.Column != want[i].Column {
 			return false
 		}
 	}
 	return true
 }
 
-// newToken creates a new tokens.
-func newToken(typ Type, text string, line, column int) Token {
-	pos := scanner.Position{}
-	pos.Line = line
-	pos.Column = column
-	return Token{pos, text, typ}
+// wantToken is the expected shape of a token in a lexerTest, described by
+// line and column instead of a token.Pos, which cannot be constructed
+// without the FileSet the Lexer under test builds.
+type wantToken struct {
+	Type   Type
+	Text   string
+	Line   int
+	Column int
+}
+
+// newToken creates a new expected token.
+func newToken(typ Type, text string, line, column int) wantToken {
+	return wantToken{typ, text, line, column}
+}
+
+// benchSource is representative input for the lexer benchmarks below.
+const benchSource = `var a = 1
+var b = 2
+while (a < 10) {
+	if (a == b && b != 0) {
+		a = a + b * 2 - 1 / b
+	} else {
+		a = a + 1
+	}
+}`
+
+// BenchmarkLex measures scanning benchSource by pulling tokens with Next.
+func BenchmarkLex(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		l := Lex("bench", strings.NewReader(benchSource))
+		for {
+			if t := l.Next(); t.Type == EOF {
+				break
+			}
+		}
+	}
+}
+
+// BenchmarkLexTokens measures scanning benchSource through the Tokens
+// channel adapter, for comparison against the goroutine-based lexer this
+// replaced.
+func BenchmarkLexTokens(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		l := Lex("bench", strings.NewReader(benchSource))
+		for t := range l.Tokens() {
+			if t.Type == EOF {
+				break
+			}
+		}
+	}
 }
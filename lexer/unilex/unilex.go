@@ -0,0 +1,312 @@
+// Copyright (c) 2014 David R. Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package unilex provides a small, language-agnostic lexer core.
+
+The Pike-style state machine and per-rune dispatch helpers that package
+lexer hard-codes for the sc token set are reusable for any small language.
+Package unilex extracts them: a Lexer drives a table of
+StateFns on demand, each of which scans input with Accept, AcceptRun,
+Ignore, Emit and friends and returns the state to run next.
+
+unilex knows nothing about any particular token set: an Item's Type is a
+plain int, chosen by the caller's own constants. By convention the zero
+value of that type should mean "end of input", since the fallback Item
+scan returns once the state machine halts has a zero Type.
+
+A consumer that needs to carry extra state across StateFns (nesting
+counters, mode stacks, and the like) can stash it in the exported Extra
+field and type-assert it back inside its StateFns.
+*/
+package unilex
+
+import (
+	"fmt"
+	"go/token"
+	"unicode/utf8"
+)
+
+// EOF is the rune returned by Peek and PeekN once the input is exhausted.
+const EOF rune = -1
+
+// Item is a lexeme produced by a Lexer: a caller-defined Type tag, the
+// matched Text, and the Pos of its first rune.
+type Item struct {
+	Pos  token.Pos
+	Text string
+	Type int
+}
+
+// StateFn is a state of the lexer. It scans some input with the Lexer's
+// Accept/Emit family of methods and returns the state to run next, or nil
+// to halt the machine.
+type StateFn func(*Lexer) StateFn
+
+// Lexer drives a StateFn machine over a string, tracking positions with a
+// go/token.FileSet so callers can resolve an Item's Pos cheaply.
+type Lexer struct {
+	input string
+	fset  *token.FileSet
+	file  *token.File
+
+	start int // start offset of the item being scanned
+	pos   int // offset of the next unread rune
+	width int // width of the last rune read by next, for Backup
+
+	state StateFn
+	item  Item
+
+	emitted bool
+	peeked  *Item
+
+	// Extra is available for a consumer's StateFns to stash language-
+	// specific state (nesting counters, ...) that does not belong in this
+	// generic Lexer.
+	Extra interface{}
+
+	modes []modeFrame
+}
+
+// Mode names a set of lexical rules that can be entered with PushMode and
+// left with PopMode, for lexing constructs whose rules change with
+// context (e.g. the expression inside a string interpolation).
+type Mode struct {
+	Name  string
+	Enter StateFn
+}
+
+// modeFrame remembers, for one PushMode call, which StateFn to resume
+// once the matching PopMode is reached.
+type modeFrame struct {
+	mode   Mode
+	resume StateFn
+}
+
+// PushMode enters m and returns m.Enter, the state a StateFn should return
+// to start running in it. resume is the StateFn to hand back from the
+// matching PopMode, i.e. the state the caller would otherwise have
+// returned to.
+func (l *Lexer) PushMode(m Mode, resume StateFn) StateFn {
+	l.modes = append(l.modes, modeFrame{m, resume})
+	return m.Enter
+}
+
+// PopMode leaves the innermost mode and returns the resume StateFn passed
+// to the matching PushMode. It returns nil if no mode is active.
+func (l *Lexer) PopMode() StateFn {
+	if len(l.modes) == 0 {
+		return nil
+	}
+	n := len(l.modes) - 1
+	resume := l.modes[n].resume
+	l.modes = l.modes[:n]
+	return resume
+}
+
+// CurrentMode reports the Mode on top of the mode stack, or the zero Mode
+// if none has been pushed.
+func (l *Lexer) CurrentMode() Mode {
+	if len(l.modes) == 0 {
+		return Mode{}
+	}
+	return l.modes[len(l.modes)-1].mode
+}
+
+// New creates a Lexer over input, starting in state start. filename is
+// recorded in the FileSet returned by FileSet, for resolving Item positions
+// to a go/token.Position.
+func New(filename, input string, start StateFn) *Lexer {
+	fset := token.NewFileSet()
+	file := fset.AddFile(filename, -1, len(input))
+	for i := 0; i < len(input); i++ {
+		if input[i] == '\n' {
+			file.AddLine(i + 1)
+		}
+	}
+	return &Lexer{input: input, fset: fset, file: file, state: start}
+}
+
+// FileSet returns the file set used to resolve this Lexer's Item positions.
+func (l *Lexer) FileSet() *token.FileSet {
+	return l.fset
+}
+
+// Next returns the next Item, driving the state machine until one is
+// emitted.
+func (l *Lexer) Next() Item {
+	if l.peeked != nil {
+		it := *l.peeked
+		l.peeked = nil
+		return it
+	}
+	return l.scan()
+}
+
+// PeekItem returns the next Item without consuming it.
+func (l *Lexer) PeekItem() Item {
+	if l.peeked == nil {
+		it := l.scan()
+		l.peeked = &it
+	}
+	return *l.peeked
+}
+
+// scan runs states until one emits an Item.
+func (l *Lexer) scan() Item {
+	for l.state != nil {
+		state := l.state
+		l.emitted = false
+		l.state = state(l)
+		if l.emitted {
+			return l.item
+		}
+	}
+	return Item{Pos: l.file.Pos(l.pos)}
+}
+
+// next consumes and returns the next rune of input, or EOF.
+func (l *Lexer) next() rune {
+	if l.pos >= len(l.input) {
+		l.width = 0
+		return EOF
+	}
+	r, w := utf8.DecodeRuneInString(l.input[l.pos:])
+	l.width = w
+	l.pos += w
+	return r
+}
+
+// Backup steps back one rune. It must be called at most once after a
+// successful Accept; AcceptWhile, AcceptRun and Peek have already backed up
+// over the rune they rejected, so a Backup after them does not undo the
+// last rune they consumed.
+func (l *Lexer) Backup() {
+	l.pos -= l.width
+}
+
+// Peek returns the next rune without consuming it.
+func (l *Lexer) Peek() rune {
+	r := l.next()
+	l.Backup()
+	return r
+}
+
+// PeekN returns the rune n runes ahead of the current position (PeekN(0)
+// is equivalent to Peek), without consuming any input.
+func (l *Lexer) PeekN(n int) rune {
+	pos := l.pos
+	r := EOF
+	for i := 0; i <= n; i++ {
+		if pos >= len(l.input) {
+			return EOF
+		}
+		var w int
+		r, w = utf8.DecodeRuneInString(l.input[pos:])
+		pos += w
+	}
+	return r
+}
+
+// isNumberRune reports whether r is an ASCII digit, for ScanNumber.
+func isNumberRune(r rune) bool {
+	return '0' <= r && r <= '9'
+}
+
+// isIdentStartRune reports whether r may start an identifier, for
+// ScanIdent: an ASCII letter or underscore.
+func isIdentStartRune(r rune) bool {
+	return r == '_' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z'
+}
+
+// isIdentRune reports whether r may continue an identifier begun with
+// isIdentStartRune, for ScanIdent.
+func isIdentRune(r rune) bool {
+	return isIdentStartRune(r) || isNumberRune(r)
+}
+
+// ScanNumber consumes a run of ASCII digits, for a StateFn scanning an
+// integer literal. Call Emit afterwards to produce the token.
+func (l *Lexer) ScanNumber() {
+	l.AcceptRun(isNumberRune)
+}
+
+// ScanIdent consumes a run of identifier runes (an ASCII letter or
+// underscore, followed by letters, digits, or underscores), for a StateFn
+// scanning an identifier or keyword. Call Emit afterwards to produce the
+// token.
+func (l *Lexer) ScanIdent() {
+	if !isIdentStartRune(l.Peek()) {
+		return
+	}
+	l.AcceptRun(isIdentRune)
+}
+
+// Accept consumes the next rune if it is in valid, reporting whether it did.
+func (l *Lexer) Accept(valid string) bool {
+	r := l.next()
+	for _, v := range valid {
+		if v == r {
+			return true
+		}
+	}
+	l.Backup()
+	return false
+}
+
+// AcceptWhile consumes a run of runes found in valid.
+func (l *Lexer) AcceptWhile(valid string) {
+	for l.Accept(valid) {
+	}
+}
+
+// AcceptRun consumes a run of runes for which pred reports true.
+func (l *Lexer) AcceptRun(pred func(rune) bool) {
+	for pred(l.next()) {
+	}
+	l.Backup()
+}
+
+// Ignore discards the input accepted so far for the item under construction.
+func (l *Lexer) Ignore() {
+	l.start = l.pos
+}
+
+// Pending returns the input accepted so far for the item under
+// construction, as Emit would return it as Text. It lets a StateFn inspect
+// the accepted text before deciding which Type to Emit it as (e.g. to
+// distinguish a keyword from a plain identifier).
+func (l *Lexer) Pending() string {
+	return l.input[l.start:l.pos]
+}
+
+// Pos returns the Pos of the next unread rune, for a StateFn that needs to
+// record a position without emitting an Item yet.
+func (l *Lexer) Pos() token.Pos {
+	return l.file.Pos(l.pos)
+}
+
+// Emit emits the input accepted so far as an Item of the given type,
+// anchored at the start of the item.
+func (l *Lexer) Emit(typ int) {
+	l.item = Item{Pos: l.file.Pos(l.start), Text: l.Pending(), Type: typ}
+	l.emitted = true
+	l.start = l.pos
+}
+
+// Errorf emits an error Item of the given type, anchored at the start of
+// the item under construction.
+func (l *Lexer) Errorf(typ int, format string, args ...interface{}) {
+	l.ErrorfAt(l.file.Pos(l.start), typ, format, args...)
+}
+
+// ErrorfAt is like Errorf but anchors the error at an explicit Pos instead
+// of the start of the current item, for diagnostics that must point at a
+// sub-position within a multi-rune item, such as the offending rune inside
+// a string literal.
+func (l *Lexer) ErrorfAt(pos token.Pos, typ int, format string, args ...interface{}) {
+	l.item = Item{Pos: pos, Text: fmt.Sprintf(format, args...), Type: typ}
+	l.emitted = true
+	l.start = l.pos
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2014 David R. Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package unilex
+
+import "testing"
+
+// A tiny token set used only by this test, to exercise the primitives
+// against a grammar unilex itself knows nothing about: signed integers
+// separated by '+'.
+const (
+	itemEOF = iota
+	itemNumber
+	itemPlus
+	itemError
+)
+
+func lexTest(l *Lexer) StateFn {
+	l.AcceptWhile(" ")
+	l.Ignore()
+	switch r := l.Peek(); {
+	case r == EOF:
+		l.Emit(itemEOF)
+		return nil
+	case r == '+':
+		l.Accept("+")
+		l.Emit(itemPlus)
+	case r == '-' || isDigit(r):
+		l.Accept("-")
+		l.AcceptRun(isDigit)
+		l.Emit(itemNumber)
+	default:
+		l.Accept(string(r))
+		l.Errorf(itemError, "unexpected rune %q", r)
+	}
+	return lexTest
+}
+
+func isDigit(r rune) bool {
+	return '0' <= r && r <= '9'
+}
+
+func collect(l *Lexer) (items []Item) {
+	for {
+		it := l.Next()
+		items = append(items, it)
+		if it.Type == itemEOF {
+			return
+		}
+	}
+}
+
+func TestLexerNumbersAndPlus(t *testing.T) {
+	l := New("test", " 1 + -23 + 4", lexTest)
+	items := collect(l)
+	want := []struct {
+		typ  int
+		text string
+	}{
+		{itemNumber, "1"},
+		{itemPlus, "+"},
+		{itemNumber, "-23"},
+		{itemPlus, "+"},
+		{itemNumber, "4"},
+		{itemEOF, ""},
+	}
+	if len(items) != len(want) {
+		t.Fatalf("got %d items, want %d: %v", len(items), len(want), items)
+	}
+	for i, w := range want {
+		if items[i].Type != w.typ || items[i].Text != w.text {
+			t.Errorf("item %d: got {%d %q}, want {%d %q}", i, items[i].Type, items[i].Text, w.typ, w.text)
+		}
+	}
+}
+
+func TestLexerPeekAndBackup(t *testing.T) {
+	l := New("test", "ab", lexTest)
+	if r := l.Peek(); r != 'a' {
+		t.Fatalf("Peek() = %q, want 'a'", r)
+	}
+	if r := l.PeekN(1); r != 'b' {
+		t.Fatalf("PeekN(1) = %q, want 'b'", r)
+	}
+	if !l.Accept("a") {
+		t.Fatalf("Accept(%q) = false, want true", "a")
+	}
+	if l.Pending() != "a" {
+		t.Fatalf("Pending() = %q, want %q", l.Pending(), "a")
+	}
+	l.Backup()
+	if l.Pending() != "" {
+		t.Fatalf("Pending() after Backup = %q, want %q", l.Pending(), "")
+	}
+	if r := l.Peek(); r != 'a' {
+		t.Fatalf("Peek() after Backup = %q, want 'a'", r)
+	}
+}
+
+func TestScanNumberAndIdent(t *testing.T) {
+	l := New("test", "abc123 456", lexTest)
+	l.ScanIdent()
+	if got := l.Pending(); got != "abc123" {
+		t.Fatalf("ScanIdent: Pending() = %q, want %q", got, "abc123")
+	}
+	l.Ignore()
+	l.Accept(" ")
+	l.Ignore()
+	l.ScanNumber()
+	if got := l.Pending(); got != "456" {
+		t.Fatalf("ScanNumber: Pending() = %q, want %q", got, "456")
+	}
+}
+
+func TestLexerError(t *testing.T) {
+	l := New("test", "1 ? 2", lexTest)
+	items := collect(l)
+	if len(items) != 4 {
+		t.Fatalf("got %d items, want 4: %v", len(items), items)
+	}
+	if items[1].Type != itemError || items[1].Text != `unexpected rune '?'` {
+		t.Errorf("got %v, want error item for '?'", items[1])
+	}
+}
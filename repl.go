@@ -0,0 +1,49 @@
+// Copyright (c) 2014 David R. Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/davidrjenni/sc/lexer"
+)
+
+const (
+	promptReady = ">> "
+	promptMore  = ".. "
+)
+
+// repl reads sc source from in a line at a time and prints the tokens the
+// lexer produces for each line to out, in the style of the Monkey REPL.
+// It prompts with promptMore instead of promptReady while a submission is
+// left open (e.g. by an unclosed brace or paren), so a statement can be
+// spread over several lines.
+func repl(in io.Reader, out io.Writer) {
+	scanner := bufio.NewScanner(in)
+	l := lexer.Lex("repl", strings.NewReader(""))
+	prompt := promptReady
+	for {
+		fmt.Fprint(out, prompt)
+		if !scanner.Scan() {
+			return
+		}
+		l.Feed(strings.NewReader(scanner.Text() + "\n"))
+		for {
+			t := l.Next()
+			if t.Type == lexer.EOF {
+				break
+			}
+			fmt.Fprintln(out, t)
+		}
+		if l.AtStatementBoundary() {
+			prompt = promptReady
+		} else {
+			prompt = promptMore
+		}
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright (c) 2014 David R. Jenni. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestRepl checks that repl prompts with promptReady between statements,
+// prints the tokens of each line one per line, and switches to
+// promptMore while a brace is left open across lines.
+func TestRepl(t *testing.T) {
+	in := strings.NewReader("var a = 1\nwhile (a < 2) {\na = a + 1\n}\n")
+	var out bytes.Buffer
+	repl(in, &out)
+
+	want := promptReady + lines(`"var"`, `"a"`, `"="`, `"1"`) +
+		promptReady + lines(`"while"`, `"("`, `"a"`, `"<"`, `"2"`, `")"`, `"{"`) +
+		promptMore + lines(`"a"`, `"="`, `"a"`, `"+"`, `"1"`) +
+		promptMore + lines(`"}"`) +
+		promptReady
+
+	if got := out.String(); got != want {
+		t.Errorf("got:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+// lines joins each of s with a trailing newline, as repl prints one token per line.
+func lines(s ...string) string {
+	return strings.Join(s, "\n") + "\n"
+}
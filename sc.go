@@ -10,6 +10,7 @@ Installation:
 
 Usage:
 	% sc <file> [flags]
+	% sc repl
 
 The flags are:
 	-o <file>
@@ -21,5 +22,11 @@ Example usage:
 */
 package main
 
+import "os"
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "repl" {
+		repl(os.Stdin, os.Stdout)
+		return
+	}
 }